@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"powerdns.com/platform/lightningstream/syncer"
+)
+
+// newCompactCmd returns the one-shot "compact" subcommand: it runs a single
+// Compactor pass over the shadow DBIs and exits, for use from cron or by an
+// operator reclaiming space without waiting for the next scheduled pass of
+// the background compaction loop (syncer.Compactor.Run).
+func newCompactCmd() *cobra.Command {
+	conf := syncer.DefaultCompactorConfig()
+	cmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Run a single tombstone compaction pass over the shadow DBIs and exit",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCompactOnce(cmd.Context(), conf)
+		},
+	}
+	cmd.Flags().DurationVar(&conf.Retention, "retention", conf.Retention,
+		"minimum tombstone age before it is eligible for removal")
+	cmd.Flags().IntVar(&conf.MaxKeysPerBatch, "max-keys-per-batch", conf.MaxKeysPerBatch,
+		"maximum number of keys deleted per write transaction")
+	cmd.Flags().Int64Var(&conf.MaxBytesPerBatch, "max-bytes-per-batch", conf.MaxBytesPerBatch,
+		"maximum tombstone bytes reclaimed per write transaction")
+	return cmd
+}
+
+func runCompactOnce(ctx context.Context, conf syncer.CompactorConfig) error {
+	if syncer.OpenShadowDBIs == nil {
+		return fmt.Errorf("compact: syncer.OpenShadowDBIs is not set, the storage layer was not initialized")
+	}
+	dbis, peers, err := syncer.OpenShadowDBIs()
+	if err != nil {
+		return fmt.Errorf("compact: open shadow DBIs: %w", err)
+	}
+	c := syncer.NewCompactor(conf, dbis, peers)
+	stats, err := c.RunOnce(ctx)
+	if err != nil {
+		return fmt.Errorf("compact: %w", err)
+	}
+	for _, s := range stats {
+		fmt.Printf("%s: removed %d entries, reclaimed %d bytes, safe horizon %s\n",
+			s.DBI, s.EntriesRemoved, s.BytesReclaimed, s.SafeHorizon.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(newCompactCmd())
+}
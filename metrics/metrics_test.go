@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"powerdns.com/platform/lightningstream/metrics"
+	"powerdns.com/platform/lightningstream/snapshot"
+	"powerdns.com/platform/lightningstream/syncer"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	metrics.Handler().ServeHTTP(rec, req)
+	return rec.Body.String()
+}
+
+func TestTimestampedIteratorMerge_UpdatesMetrics(t *testing.T) {
+	const dbi = "metrics-test-timestamped"
+	it := &syncer.TimestampedIterator{
+		Entries: []snapshot.KV{{Key: []byte("k1"), Value: []byte("v1"), TimestampNano: 100}},
+		DBIName: dbi,
+	}
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := it.Merge(nil); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	body := scrape(t)
+	if !strings.Contains(body, `lightningstream_merge_entries_seen_total{dbi="`+dbi+`"} 1`) {
+		t.Fatalf("expected entries_seen_total=1 for dbi %q, got:\n%s", dbi, body)
+	}
+	if !strings.Contains(body, `lightningstream_merge_entries_updated_total{dbi="`+dbi+`"} 1`) {
+		t.Fatalf("expected entries_updated_total=1 for dbi %q, got:\n%s", dbi, body)
+	}
+}
+
+func TestPlainIteratorMerge_UpdatesMetrics(t *testing.T) {
+	const dbi = "metrics-test-plain"
+	it := &syncer.PlainIterator{
+		Entries: []snapshot.KV{{Key: []byte("k1"), Value: []byte("v1")}},
+		DBIName: dbi,
+	}
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := it.Merge(nil); err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+
+	body := scrape(t)
+	if !strings.Contains(body, `lightningstream_merge_entries_seen_total{dbi="`+dbi+`"} 1`) {
+		t.Fatalf("expected entries_seen_total=1 for dbi %q, got:\n%s", dbi, body)
+	}
+	if !strings.Contains(body, `lightningstream_merge_entries_updated_total{dbi="`+dbi+`"} 1`) {
+		t.Fatalf("expected entries_updated_total=1 for dbi %q, got:\n%s", dbi, body)
+	}
+}
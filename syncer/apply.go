@@ -0,0 +1,178 @@
+package syncer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"powerdns.com/platform/lightningstream/config"
+	"powerdns.com/platform/lightningstream/metrics"
+)
+
+// DBWriter is the narrow view into an LMDB DBI that ApplySnapshot needs to
+// drive a TimestampedIterator to completion: look up the value currently
+// stored for a key, write back the merged result, and enumerate keys that
+// are not part of the snapshot being merged in.
+type DBWriter interface {
+	// Get returns the value currently stored for key, or nil if it does
+	// not exist.
+	Get(key []byte) (val []byte, err error)
+	// Put stores val for key, overwriting any existing value.
+	Put(key, val []byte) error
+	// ForEach calls fn for every key currently stored in the DBI, in key
+	// order, until fn returns false or all keys have been visited.
+	ForEach(fn func(key []byte) bool) error
+}
+
+// ApplySnapshot is the top-level snapshot-apply loop: it drives it to
+// completion against db, merging every entry in the snapshot into the DBI,
+// and is the single point where a whole-snapshot
+// MergeObserver.OnSnapshotApplied fires and a
+// metrics.ObserveSnapshotMergeDuration sample is recorded. It returns the
+// resulting MergeStats so callers embedding lightningstream as a library
+// can read the same counts without depending on Prometheus.
+//
+// ApplySnapshot is also the single place that wires dbiConf into it: the
+// MergeStrategy and legacy-header flag configured for the DBI are resolved
+// here via StrategyForDBI and set on it before it is driven, so a real
+// merge always uses what the operator configured rather than whatever it
+// happened to be constructed with. it.DefaultTimestampNano is likewise set
+// from meta.Timestamp, the snapshot's own generation time, so tombstones
+// written for keys that have no timestamp of their own still get one.
+//
+// tombstoneMissing controls whether destination keys absent from the
+// snapshot's entries are tombstoned as deletions. This is only sound when
+// the snapshot is guaranteed to be a full, current picture of every key the
+// destination DBI holds -- true for the main-DB-to-shadow-DB sync use case
+// described on TimestampedIterator, where there is a single source of
+// truth. It is NOT true for a remote snapshot merged in from one peer among
+// several: a key already merged in from peer B will legitimately be
+// "missing" from peer A's snapshot, and tombstoning it there would delete
+// data peer A never had a say in. Pass false for remote peer merges and
+// rely on explicit tombstone entries arriving in the snapshot instead; pass
+// true only for the main-to-shadow call site.
+//
+// peers may be nil. When set, this is also where peer generation tracking
+// piggybacks on the snapshot metadata exchange: meta is what every snapshot
+// apply already carries the peer and generation in, so ApplySnapshot
+// records it on peers on successful completion, feeding the Compactor's
+// safe-horizon calculation without a separate exchange.
+func ApplySnapshot(db DBWriter, it *TimestampedIterator, dbiConf config.DBI, peers *PeerGenerations, meta SnapshotMeta, tombstoneMissing bool) (MergeStats, error) {
+	strategy, err := StrategyForDBI(dbiConf)
+	if err != nil {
+		return MergeStats{}, fmt.Errorf("apply snapshot: %w", err)
+	}
+	it.Strategy = strategy
+	it.LegacyFormat = dbiConf.LegacyFormat
+	it.DefaultTimestampNano = uint64(meta.Timestamp.UnixNano())
+
+	collector := &statsCollector{}
+	observers := MultiObserver{collector}
+	if it.Observer != nil {
+		observers = append(observers, it.Observer)
+	}
+	it.Observer = observers
+
+	start := time.Now()
+	seen := make(map[string]struct{})
+	for {
+		key, err := it.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return collector.stats, fmt.Errorf("apply snapshot: next: %w", err)
+		}
+		seen[string(key)] = struct{}{}
+		oldval, err := db.Get(key)
+		if err != nil {
+			return collector.stats, fmt.Errorf("apply snapshot: get %x: %w", key, err)
+		}
+		// Counted here rather than in the collector, so entries that Merge
+		// does not report to observers are seen too, as in the metrics.
+		collector.stats.EntriesSeen++
+		newval, err := it.Merge(oldval)
+		if err != nil {
+			if errors.Is(err, ErrNoTimestamp) {
+				collector.stats.Errors++
+				continue
+			}
+			return collector.stats, fmt.Errorf("apply snapshot: merge %x: %w", key, err)
+		}
+		if bytes.Equal(newval, oldval) {
+			continue
+		}
+		if err := db.Put(key, newval); err != nil {
+			return collector.stats, fmt.Errorf("apply snapshot: put %x: %w", key, err)
+		}
+	}
+
+	if tombstoneMissing {
+		// Keys left in the destination that this snapshot does not mention
+		// were deleted at the source, and must be tombstoned too. Collect
+		// them before writing anything back, so mutating the DBI does not
+		// disturb the ForEach scan in progress.
+		var missing [][]byte
+		if err := db.ForEach(func(key []byte) bool {
+			if _, ok := seen[string(key)]; !ok {
+				missing = append(missing, append([]byte(nil), key...))
+			}
+			return true
+		}); err != nil {
+			return collector.stats, fmt.Errorf("apply snapshot: scan destination: %w", err)
+		}
+		for _, key := range missing {
+			oldval, err := db.Get(key)
+			if err != nil {
+				return collector.stats, fmt.Errorf("apply snapshot: get %x: %w", key, err)
+			}
+			newval, err := it.Clean(key, oldval)
+			if err != nil {
+				return collector.stats, fmt.Errorf("apply snapshot: clean %x: %w", key, err)
+			}
+			if bytes.Equal(newval, oldval) {
+				continue
+			}
+			if err := db.Put(key, newval); err != nil {
+				return collector.stats, fmt.Errorf("apply snapshot: put %x: %w", key, err)
+			}
+		}
+	}
+
+	metrics.ObserveSnapshotMergeDuration(time.Since(start))
+	observers.OnSnapshotApplied(meta, collector.stats)
+	if peers != nil {
+		peers.Observe(meta.Peer, meta.Generation)
+	}
+	return collector.stats, nil
+}
+
+// statsCollector is a MergeObserver that ApplySnapshot attaches internally
+// to tally a MergeStats summary for the snapshot being applied, alongside
+// whatever observer the caller configured.
+type statsCollector struct {
+	stats MergeStats
+}
+
+func (s *statsCollector) OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision) {
+	switch decision {
+	case DecisionInserted, DecisionUpdated:
+		s.stats.EntriesUpdated++
+	case DecisionKept:
+		s.stats.EntriesKept++
+	case DecisionTieKept:
+		s.stats.TieBreakRejections++
+	}
+}
+
+func (s *statsCollector) OnEntryTombstoned(dbi string, key, oldVal []byte) {
+	s.stats.EntriesSeen++
+	s.stats.TombstonesWritten++
+}
+
+func (s *statsCollector) OnSnapshotApplied(meta SnapshotMeta, stats MergeStats) {
+	// Nothing to do: ApplySnapshot reports its own collected stats once,
+	// after this observer itself is invoked via MultiObserver.
+}
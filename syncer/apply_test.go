@@ -0,0 +1,195 @@
+package syncer
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"powerdns.com/platform/lightningstream/config"
+	"powerdns.com/platform/lightningstream/snapshot"
+)
+
+// fakeDBWriter is an in-memory DBWriter for testing ApplySnapshot without a
+// real LMDB.
+type fakeDBWriter struct {
+	values map[string][]byte // key -> current value
+}
+
+func newFakeDBWriter() *fakeDBWriter {
+	return &fakeDBWriter{values: make(map[string][]byte)}
+}
+
+func (f *fakeDBWriter) Get(key []byte) ([]byte, error) {
+	return f.values[string(key)], nil
+}
+
+func (f *fakeDBWriter) Put(key, val []byte) error {
+	f.values[string(key)] = append([]byte(nil), val...)
+	return nil
+}
+
+func (f *fakeDBWriter) ForEach(fn func(key []byte) bool) error {
+	for k := range f.values {
+		if !fn([]byte(k)) {
+			break
+		}
+	}
+	return nil
+}
+
+// fakeMergeObserver is a MergeObserver that just counts OnSnapshotApplied
+// calls and remembers the stats it was last called with, for asserting
+// ApplySnapshot fires it exactly once per call.
+type fakeMergeObserver struct {
+	snapshotApplied int
+	lastStats       MergeStats
+}
+
+func (f *fakeMergeObserver) OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision) {
+}
+
+func (f *fakeMergeObserver) OnEntryTombstoned(dbi string, key, oldVal []byte) {}
+
+func (f *fakeMergeObserver) OnSnapshotApplied(meta SnapshotMeta, stats MergeStats) {
+	f.snapshotApplied++
+	f.lastStats = stats
+}
+
+func encodeHeader(version byte, ts uint64, val []byte) []byte {
+	buf := make([]byte, HeaderSize, HeaderSize+len(val))
+	buf[0] = version
+	binary.BigEndian.PutUint64(buf[1:HeaderSize], ts)
+	return append(buf, val...)
+}
+
+// TestApplySnapshot_InsertsUpdatesKeepsAndTombstones exercises
+// tombstoneMissing=true, i.e. the main-DB-to-shadow-DB sync use case where
+// the snapshot is a full, current picture of every key and anything it
+// doesn't mention really was deleted at the source.
+func TestApplySnapshot_InsertsUpdatesKeepsAndTombstones(t *testing.T) {
+	db := newFakeDBWriter()
+	db.values["update-me"] = encodeHeader(versionLWW, 50, []byte("old"))
+	db.values["keep-me"] = encodeHeader(versionLWW, 500, []byte("newer"))
+	db.values["delete-me"] = encodeHeader(versionLWW, 10, []byte("gone"))
+
+	it := &TimestampedIterator{Entries: []snapshot.KV{
+		{Key: []byte("insert-me"), Value: []byte("v1"), TimestampNano: 100},
+		{Key: []byte("update-me"), Value: []byte("new"), TimestampNano: 200},
+		{Key: []byte("keep-me"), Value: []byte("stale"), TimestampNano: 100},
+	}}
+	obs := &fakeMergeObserver{}
+	it.Observer = obs
+
+	meta := SnapshotMeta{Peer: "p", Generation: 1, Timestamp: time.Unix(0, 999)}
+	stats, err := ApplySnapshot(db, it, config.DBI{}, nil, meta, true)
+	if err != nil {
+		t.Fatalf("ApplySnapshot: %v", err)
+	}
+
+	if stats.EntriesSeen != 4 { // insert-me, update-me, keep-me, delete-me
+		t.Fatalf("got EntriesSeen=%d, want 4", stats.EntriesSeen)
+	}
+	if stats.EntriesUpdated != 2 { // insert-me, update-me
+		t.Fatalf("got EntriesUpdated=%d, want 2", stats.EntriesUpdated)
+	}
+	if stats.EntriesKept != 1 { // keep-me
+		t.Fatalf("got EntriesKept=%d, want 1", stats.EntriesKept)
+	}
+	if stats.TombstonesWritten != 1 { // delete-me
+		t.Fatalf("got TombstonesWritten=%d, want 1", stats.TombstonesWritten)
+	}
+
+	if obs.snapshotApplied != 1 {
+		t.Fatalf("OnSnapshotApplied fired %d times, want exactly 1", obs.snapshotApplied)
+	}
+	if obs.lastStats != stats {
+		t.Fatalf("OnSnapshotApplied got stats %+v, want the returned %+v", obs.lastStats, stats)
+	}
+
+	_, _, plain, err := it.parseHeader(db.values["insert-me"])
+	if err != nil || string(plain) != "v1" {
+		t.Fatalf("insert-me = %q (err=%v), want plain %q", db.values["insert-me"], err, "v1")
+	}
+	_, _, plain, err = it.parseHeader(db.values["update-me"])
+	if err != nil || string(plain) != "new" {
+		t.Fatalf("update-me = %q (err=%v), want plain %q", db.values["update-me"], err, "new")
+	}
+	_, deleteTS, plain, err := it.parseHeader(db.values["delete-me"])
+	if err != nil || len(plain) != 0 {
+		t.Fatalf("delete-me = %q (err=%v), want an empty tombstone", db.values["delete-me"], err)
+	}
+	if deleteTS != uint64(meta.Timestamp.UnixNano()) {
+		t.Fatalf("delete-me tombstone ts = %d, want meta.Timestamp's %d", deleteTS, meta.Timestamp.UnixNano())
+	}
+}
+
+// TestApplySnapshot_DoesNotTombstoneMissingKeysForRemoteMerges exercises
+// tombstoneMissing=false, the remote multi-peer merge case: a key already
+// merged in from a different peer is legitimately absent from this peer's
+// snapshot, and must survive untouched rather than being deleted.
+func TestApplySnapshot_DoesNotTombstoneMissingKeysForRemoteMerges(t *testing.T) {
+	db := newFakeDBWriter()
+	existing := encodeHeader(versionLWW, 10, []byte("from-another-peer"))
+	db.values["other-peer-key"] = append([]byte(nil), existing...)
+
+	it := &TimestampedIterator{Entries: []snapshot.KV{
+		{Key: []byte("this-peer-key"), Value: []byte("v1"), TimestampNano: 100},
+	}}
+	meta := SnapshotMeta{Peer: "p", Generation: 1, Timestamp: time.Unix(0, 999)}
+
+	if _, err := ApplySnapshot(db, it, config.DBI{}, nil, meta, false); err != nil {
+		t.Fatalf("ApplySnapshot: %v", err)
+	}
+
+	if string(db.values["other-peer-key"]) != string(existing) {
+		t.Fatalf("other-peer-key = %q, want it left untouched at %q", db.values["other-peer-key"], existing)
+	}
+}
+
+func snapshotDurationSampleCount(t *testing.T) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("Gather: %v", err)
+	}
+	for _, fam := range families {
+		if fam.GetName() != "lightningstream_merge_snapshot_duration_seconds" {
+			continue
+		}
+		for _, m := range fam.GetMetric() {
+			return m.GetHistogram().GetSampleCount()
+		}
+	}
+	return 0
+}
+
+func TestApplySnapshot_RecordsSnapshotDurationOnce(t *testing.T) {
+	db := newFakeDBWriter()
+	it := &TimestampedIterator{Entries: []snapshot.KV{{Key: []byte("k"), Value: []byte("v"), TimestampNano: 100}}}
+
+	before := snapshotDurationSampleCount(t)
+	if _, err := ApplySnapshot(db, it, config.DBI{}, nil, SnapshotMeta{Peer: "p", Generation: 1}, false); err != nil {
+		t.Fatalf("ApplySnapshot: %v", err)
+	}
+	after := snapshotDurationSampleCount(t)
+	if after != before+1 {
+		t.Fatalf("snapshot duration sample count went from %d to %d, want exactly +1", before, after)
+	}
+}
+
+func TestApplySnapshot_ObservesPeerGeneration(t *testing.T) {
+	db := newFakeDBWriter()
+	it := &TimestampedIterator{Entries: []snapshot.KV{{Key: []byte("k"), Value: []byte("v"), TimestampNano: 100}}}
+	peers := NewPeerGenerations()
+	meta := SnapshotMeta{Peer: "peer-a", Generation: 42}
+
+	if _, err := ApplySnapshot(db, it, config.DBI{}, peers, meta, false); err != nil {
+		t.Fatalf("ApplySnapshot: %v", err)
+	}
+
+	got, found := peers.Min()
+	if !found || got != 42 {
+		t.Fatalf("peers.Min() = (%d, %v), want (42, true): ApplySnapshot should feed peer generations from SnapshotMeta", got, found)
+	}
+}
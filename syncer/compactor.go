@@ -0,0 +1,262 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"powerdns.com/platform/lightningstream/utils"
+)
+
+// CompactorConfig controls how aggressively the Compactor reclaims
+// tombstone space in the shadow DBIs.
+type CompactorConfig struct {
+	// Interval is how often the background compaction loop runs a pass.
+	// Zero disables Run; RunOnce can still be invoked directly, e.g. from
+	// the one-shot CLI subcommand.
+	Interval time.Duration
+	// Retention is the minimum age a tombstone must reach before it is
+	// eligible for removal, regardless of what peers have seen.
+	Retention time.Duration
+	// MaxKeysPerBatch bounds how many keys are deleted per write txn, so a
+	// compaction pass does not block the merge loop for too long.
+	MaxKeysPerBatch int
+	// MaxBytesPerBatch bounds how many tombstone bytes are reclaimed per
+	// write txn.
+	MaxBytesPerBatch int64
+}
+
+// DefaultCompactorConfig returns conservative defaults for the Compactor.
+func DefaultCompactorConfig() CompactorConfig {
+	return CompactorConfig{
+		Interval:         10 * time.Minute,
+		Retention:        24 * time.Hour,
+		MaxKeysPerBatch:  10000,
+		MaxBytesPerBatch: 64 << 20, // 64 MiB
+	}
+}
+
+// PeerGenerations tracks, for each known peer, the oldest snapshot
+// generation it has told us it still references. It piggybacks on the
+// existing snapshot metadata exchange: callers should call Observe whenever
+// a peer's snapshot metadata is received, and Forget when a peer is
+// dropped.
+type PeerGenerations struct {
+	mu     sync.Mutex
+	oldest map[string]uint64 // peer name -> oldest generation (timestamp nanos) it still references
+}
+
+// NewPeerGenerations returns an empty PeerGenerations tracker.
+func NewPeerGenerations() *PeerGenerations {
+	return &PeerGenerations{oldest: make(map[string]uint64)}
+}
+
+// Observe records the oldest generation peer still references.
+func (p *PeerGenerations) Observe(peer string, generation uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.oldest[peer] = generation
+}
+
+// Forget removes a peer, e.g. once it has been removed from the config.
+func (p *PeerGenerations) Forget(peer string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.oldest, peer)
+}
+
+// Min returns the oldest generation referenced by any known peer, and false
+// if no peer has been observed yet.
+func (p *PeerGenerations) Min() (generation uint64, found bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, gen := range p.oldest {
+		if !found || gen < generation {
+			generation = gen
+			found = true
+		}
+	}
+	return generation, found
+}
+
+// CompactableDBI is the narrow view of a shadow DBI the Compactor needs. It
+// is implemented by the LMDB-backed shadow database wrapper.
+type CompactableDBI interface {
+	// Name returns the DBI name, used in logs.
+	Name() string
+	// ScanTombstones calls fn for every tombstone entry (a bare header, no
+	// value) currently stored, in key order, until fn returns false or all
+	// tombstones have been visited.
+	ScanTombstones(fn func(key []byte, ts uint64) bool) error
+	// DeleteBatch removes the given keys in a single bounded write txn and
+	// returns the number of value bytes reclaimed.
+	DeleteBatch(keys [][]byte) (bytesReclaimed int64, err error)
+}
+
+// CompactionStats summarizes a single compaction pass over one DBI.
+type CompactionStats struct {
+	DBI             string
+	EntriesRemoved  int
+	BytesReclaimed  int64
+	OldestTombstone time.Time // zero if no tombstones survived the pass
+	SafeHorizon     time.Time
+}
+
+// Compactor walks shadow DBIs and drops tombstones that are older than a
+// safe horizon, so storage does not grow without bound under high churn.
+type Compactor struct {
+	conf  CompactorConfig
+	dbis  []CompactableDBI
+	peers *PeerGenerations
+}
+
+// NewCompactor returns a Compactor for the given shadow DBIs. peers may be
+// nil, in which case the safe horizon is based on Retention alone.
+func NewCompactor(conf CompactorConfig, dbis []CompactableDBI, peers *PeerGenerations) *Compactor {
+	return &Compactor{conf: conf, dbis: dbis, peers: peers}
+}
+
+// OpenShadowDBIs is set by the storage layer at startup to how the current
+// process's shadow DBIs can be opened as CompactableDBI, plus the
+// PeerGenerations tracker fed by the running syncer. It exists so that
+// one-shot tools (the "compact" CLI subcommand) and the background
+// compaction loop (StartBackground) can build a Compactor against the
+// real, already-running environment's LMDB without this package depending
+// on the storage layer directly.
+var OpenShadowDBIs func() ([]CompactableDBI, *PeerGenerations, error)
+
+// StartBackground builds a Compactor from OpenShadowDBIs and starts its
+// background compaction loop in its own goroutine, returning the Compactor
+// so the caller (the syncer's main loop) can hold onto it; canceling ctx
+// stops the loop. This is the live counterpart to the one-shot "compact"
+// CLI subcommand, which calls RunOnce directly instead of Run.
+func StartBackground(ctx context.Context, conf CompactorConfig) (*Compactor, error) {
+	if OpenShadowDBIs == nil {
+		return nil, fmt.Errorf("compactor: OpenShadowDBIs is not set, the storage layer was not initialized")
+	}
+	dbis, peers, err := OpenShadowDBIs()
+	if err != nil {
+		return nil, fmt.Errorf("compactor: open shadow DBIs: %w", err)
+	}
+	c := NewCompactor(conf, dbis, peers)
+	go func() {
+		if err := c.Run(ctx); err != nil {
+			logrus.WithError(err).Error("background compaction loop exited")
+		}
+	}()
+	return c, nil
+}
+
+// Run starts the background compaction loop and blocks until ctx is
+// canceled. It is a no-op if Interval is 0.
+func (c *Compactor) Run(ctx context.Context) error {
+	if c.conf.Interval <= 0 {
+		return nil
+	}
+	for {
+		if _, err := c.RunOnce(ctx); err != nil {
+			logrus.WithError(err).Error("compaction pass failed")
+		}
+		if err := utils.SleepContext(ctx, c.conf.Interval); err != nil {
+			return nil
+		}
+	}
+}
+
+// RunOnce runs a single compaction pass over all configured DBIs and
+// returns a summary per DBI. This is what the one-shot CLI subcommand
+// calls.
+func (c *Compactor) RunOnce(ctx context.Context) ([]CompactionStats, error) {
+	horizon := c.safeHorizonNano(time.Now())
+	all := make([]CompactionStats, 0, len(c.dbis))
+	for _, dbi := range c.dbis {
+		stats, err := c.compactDBI(ctx, dbi, horizon)
+		if err != nil {
+			return all, fmt.Errorf("compactor: %s: %w", dbi.Name(), err)
+		}
+		all = append(all, stats)
+		logrus.WithFields(logrus.Fields{
+			"dbi":             stats.DBI,
+			"entries_removed": stats.EntriesRemoved,
+			"bytes_reclaimed": stats.BytesReclaimed,
+			"safe_horizon":    stats.SafeHorizon,
+		}).Info("compaction pass complete")
+	}
+	return all, nil
+}
+
+// safeHorizonNano is the minimum of (a) the oldest generation any known
+// peer still references and (b) now minus the configured retention.
+// Tombstones older than this are safe to drop.
+func (c *Compactor) safeHorizonNano(now time.Time) uint64 {
+	horizon := uint64(now.Add(-c.conf.Retention).UnixNano())
+	if c.peers != nil {
+		if peerHorizon, ok := c.peers.Min(); ok && peerHorizon < horizon {
+			horizon = peerHorizon
+		}
+	}
+	return horizon
+}
+
+func (c *Compactor) compactDBI(ctx context.Context, dbi CompactableDBI, horizon uint64) (CompactionStats, error) {
+	stats := CompactionStats{
+		DBI:         dbi.Name(),
+		SafeHorizon: time.Unix(0, int64(horizon)),
+	}
+	var (
+		batch      [][]byte
+		batchBytes int64
+		oldest     = uint64(math.MaxUint64)
+		scanErr    error
+	)
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		reclaimed, err := dbi.DeleteBatch(batch)
+		if err != nil {
+			scanErr = err
+			return false
+		}
+		stats.EntriesRemoved += len(batch)
+		stats.BytesReclaimed += reclaimed
+		batch = batch[:0]
+		batchBytes = 0
+		return true
+	}
+
+	err := dbi.ScanTombstones(func(key []byte, ts uint64) bool {
+		if utils.IsCanceled(ctx) {
+			return false
+		}
+		if ts >= horizon {
+			if ts < oldest {
+				oldest = ts
+			}
+			return true
+		}
+		batch = append(batch, key)
+		batchBytes += HeaderSize
+		if len(batch) >= c.conf.MaxKeysPerBatch || batchBytes >= c.conf.MaxBytesPerBatch {
+			return flush()
+		}
+		return true
+	})
+	if err != nil {
+		return stats, err
+	}
+	if scanErr != nil {
+		return stats, scanErr
+	}
+	if !flush() {
+		return stats, scanErr
+	}
+	if oldest != math.MaxUint64 {
+		stats.OldestTombstone = time.Unix(0, int64(oldest))
+	}
+	return stats, nil
+}
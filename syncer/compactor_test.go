@@ -0,0 +1,169 @@
+package syncer
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+)
+
+// fakeCompactableDBI is an in-memory CompactableDBI for testing the
+// Compactor's horizon and batching logic without a real LMDB.
+type fakeCompactableDBI struct {
+	name       string
+	tombstones map[string]uint64 // key -> timestamp nanos
+	deleted    [][]byte
+	batchSizes []int // len(keys) of every DeleteBatch call
+}
+
+func (f *fakeCompactableDBI) Name() string { return f.name }
+
+func (f *fakeCompactableDBI) ScanTombstones(fn func(key []byte, ts uint64) bool) error {
+	keys := make([]string, 0, len(f.tombstones))
+	for k := range f.tombstones {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn([]byte(k), f.tombstones[k]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (f *fakeCompactableDBI) DeleteBatch(keys [][]byte) (int64, error) {
+	var reclaimed int64
+	f.batchSizes = append(f.batchSizes, len(keys))
+	for _, k := range keys {
+		delete(f.tombstones, string(k))
+		f.deleted = append(f.deleted, k)
+		reclaimed += HeaderSize
+	}
+	return reclaimed, nil
+}
+
+func TestCompactor_SafeHorizonUsesTheOlderOfRetentionAndPeerGenerations(t *testing.T) {
+	now := time.Unix(1000, 0)
+	conf := CompactorConfig{Retention: 100 * time.Second}
+
+	c := NewCompactor(conf, nil, nil)
+	if got := c.safeHorizonNano(now); got != uint64(now.Add(-100*time.Second).UnixNano()) {
+		t.Fatalf("with no peers, horizon should be retention-only, got %d", got)
+	}
+
+	peers := NewPeerGenerations()
+	peers.Observe("a", uint64(now.Add(-500*time.Second).UnixNano())) // older than retention horizon
+	peers.Observe("b", uint64(now.Add(-10*time.Second).UnixNano()))  // newer than retention horizon
+	c = NewCompactor(conf, nil, peers)
+	want := uint64(now.Add(-500 * time.Second).UnixNano())
+	if got := c.safeHorizonNano(now); got != want {
+		t.Fatalf("got horizon %d, want the oldest peer generation %d", got, want)
+	}
+}
+
+func TestCompactor_RunOnceRemovesOnlyTombstonesOlderThanTheHorizon(t *testing.T) {
+	// RunOnce computes its horizon from the real clock (time.Now()), so
+	// tombstone ages here are relative to it rather than to a fixed instant.
+	retention := time.Hour
+	approxHorizon := uint64(time.Now().Add(-retention).UnixNano())
+	dbi := &fakeCompactableDBI{
+		name: "test",
+		tombstones: map[string]uint64{
+			"old1": approxHorizon - uint64(time.Hour),
+			"old2": approxHorizon - uint64(time.Minute),
+			"new1": uint64(time.Now().UnixNano()), // well within the retention window
+		},
+	}
+	conf := CompactorConfig{
+		Retention:        retention,
+		MaxKeysPerBatch:  10000,
+		MaxBytesPerBatch: 1 << 30,
+	}
+	c := NewCompactor(conf, []CompactableDBI{dbi}, nil)
+	stats, err := c.RunOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if len(stats) != 1 || stats[0].EntriesRemoved != 2 {
+		t.Fatalf("got stats %+v, want 2 entries removed", stats)
+	}
+	if _, ok := dbi.tombstones["new1"]; !ok {
+		t.Fatal("new1 should have survived compaction")
+	}
+	if _, ok := dbi.tombstones["old1"]; ok {
+		t.Fatal("old1 should have been removed")
+	}
+}
+
+func TestCompactor_RunOnceRespectsMaxKeysPerBatch(t *testing.T) {
+	dbi := &fakeCompactableDBI{name: "test", tombstones: map[string]uint64{}}
+	for i := 0; i < 5; i++ {
+		dbi.tombstones[string(rune('a'+i))] = 0 // all well within the horizon
+	}
+	conf := CompactorConfig{
+		Retention:        time.Hour,
+		MaxKeysPerBatch:  2,
+		MaxBytesPerBatch: 1 << 30,
+	}
+	c := NewCompactor(conf, []CompactableDBI{dbi}, nil)
+	if _, err := c.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce: %v", err)
+	}
+	if len(dbi.tombstones) != 0 {
+		t.Fatalf("expected all tombstones removed across batches, %d left", len(dbi.tombstones))
+	}
+	if len(dbi.deleted) != 5 {
+		t.Fatalf("got %d deletions, want 5", len(dbi.deleted))
+	}
+	for _, n := range dbi.batchSizes {
+		if n > conf.MaxKeysPerBatch {
+			t.Fatalf("got batch sizes %v, want none above MaxKeysPerBatch=%d", dbi.batchSizes, conf.MaxKeysPerBatch)
+		}
+	}
+}
+
+func TestStartBackground_RunsAPassAgainstOpenShadowDBIs(t *testing.T) {
+	dbi := &fakeCompactableDBI{
+		name:       "test",
+		tombstones: map[string]uint64{"old": 0},
+	}
+	peers := NewPeerGenerations()
+	prev := OpenShadowDBIs
+	OpenShadowDBIs = func() ([]CompactableDBI, *PeerGenerations, error) {
+		return []CompactableDBI{dbi}, peers, nil
+	}
+	defer func() { OpenShadowDBIs = prev }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := StartBackground(ctx, CompactorConfig{
+		Interval:         time.Millisecond,
+		Retention:        time.Hour,
+		MaxKeysPerBatch:  10000,
+		MaxBytesPerBatch: 1 << 30,
+	})
+	if err != nil {
+		t.Fatalf("StartBackground: %v", err)
+	}
+	if c == nil {
+		t.Fatal("StartBackground returned a nil Compactor")
+	}
+	deadline := time.Now().Add(time.Second)
+	for len(dbi.deleted) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if len(dbi.deleted) == 0 {
+		t.Fatal("expected the background loop to have run at least one compaction pass")
+	}
+}
+
+func TestStartBackground_ErrorsWithoutOpenShadowDBIs(t *testing.T) {
+	prev := OpenShadowDBIs
+	OpenShadowDBIs = nil
+	defer func() { OpenShadowDBIs = prev }()
+
+	if _, err := StartBackground(context.Background(), DefaultCompactorConfig()); err == nil {
+		t.Fatal("expected an error when OpenShadowDBIs is not set")
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 
 	"github.com/sirupsen/logrus"
+	"powerdns.com/platform/lightningstream/metrics"
 	"powerdns.com/platform/lightningstream/snapshot"
 )
 
@@ -16,6 +17,18 @@ import (
 // timestamp is 0.
 var ErrNoTimestamp = errors.New("no timestamp for entry")
 
+// legacyHeaderSize is the on-disk header size used before per-entry merge
+// strategy versioning was introduced: just an 8 byte big endian timestamp,
+// with no room for a version tag. Tombstones written by older versions are
+// exactly this size and are treated as version 0 (LWW) on read; any other
+// legacy value is re-encoded into the current header format the next time
+// it is merged.
+const legacyHeaderSize = 8
+
+// HeaderSize is the current on-disk header size: a 1 byte merge strategy
+// version tag followed by an 8 byte big endian timestamp.
+const HeaderSize = legacyHeaderSize + 1
+
 // TimestampedIterator iterates over a snapshot DBI and updates the LMDB with
 // values that are prefixed with a timestamp header.
 // This iterator has two uses:
@@ -26,12 +39,36 @@ var ErrNoTimestamp = errors.New("no timestamp for entry")
 type TimestampedIterator struct {
 	Entries              []snapshot.KV // LMDB contents as raw values without timestamp
 	DefaultTimestampNano uint64        // Timestamp to add to entries that do not have one
+	Strategy             MergeStrategy // Conflict resolution policy for this DBI, defaults to LWWStrategy
+	DBIName              string        // DBI name, passed through to Observer
+	Observer             MergeObserver // Optional, notified of merge/tombstone decisions
+
+	// LegacyFormat must be set for a DBI that still has entries written
+	// before per-entry strategy versioning was introduced (a bare 8-byte
+	// timestamp, with no version tag and no room for one). Once a value is
+	// longer than that bare timestamp, its length alone cannot tell a live
+	// legacy entry (8-byte timestamp + value) apart from a current-format
+	// entry (1-byte version + 8-byte timestamp + value) -- both parse as
+	// "at least HeaderSize bytes". So this is not inferred from length;
+	// the caller must track it explicitly per DBI, e.g. clearing it only
+	// after a one-time pass has rewritten every entry in the current
+	// format.
+	LegacyFormat bool
 
 	current int
 	started bool
 	buf     []byte
 }
 
+// strategy returns the configured MergeStrategy, defaulting to the original
+// last-write-wins behaviour when none was set.
+func (it *TimestampedIterator) strategy() MergeStrategy {
+	if it.Strategy != nil {
+		return it.Strategy
+	}
+	return LWWStrategy{}
+}
+
 func (it *TimestampedIterator) Next() (key []byte, err error) {
 	if it.started {
 		it.current++
@@ -46,23 +83,41 @@ func (it *TimestampedIterator) Next() (key []byte, err error) {
 }
 
 // Merge compares the old LMDB value currently stored and the current iterator
-// value from the dump, and decides which value the LMDB should take.
-// The LMDB entries are always prefixed with a big endian 64 bit timestamp.
+// value from the dump, and decides which value the LMDB should take using
+// the configured MergeStrategy.
+// The LMDB entries are always prefixed with a header holding the merge
+// strategy version and a big endian 64 bit timestamp.
 func (it *TimestampedIterator) Merge(oldval []byte) (val []byte, err error) {
 	entry := it.Entries[it.current]
 	entryVal := entry.Value
+	metrics.ObserveEntrySeen(it.DBIName)
+	metrics.ObserveValueSize(it.DBIName, len(entryVal))
+	metrics.ObserveHighestTimestamp(it.DBIName, entry.TimestampNano)
 	if len(oldval) == 0 {
-		// Not in destination db, add with timestamp
-		return it.addTS(entryVal, entry.TimestampNano)
+		// Not in destination db yet: there is no stored entry to inherit a
+		// strategy from, so use the one currently configured for this DBI.
+		strategy := it.strategy()
+		val, err = it.addTS(entryVal, entry.TimestampNano, strategy.Version())
+		if err == nil {
+			metrics.ObserveEntryUpdated(it.DBIName)
+			it.notifyMerged(entry.Key, nil, val, DecisionInserted)
+		}
+		return val, err
 	}
-	if len(oldval) < HeaderSize {
+	oldVersion, oldTS, actualOldVal, err := it.parseHeader(oldval)
+	if err != nil {
 		// Should never happen
 		it.logDebugValue(oldval)
 		return nil, fmt.Errorf("merge: oldval in db too short: %v = %v", entry.Key, oldval)
 	}
-	oldTS := binary.BigEndian.Uint64(oldval[:HeaderSize])
+	// Resolve with the strategy that produced the stored value, not
+	// whatever is currently configured for the DBI: that is what the
+	// per-entry version tag is for, see strategyForVersion.
+	strategy, err := strategyForVersion(oldVersion)
+	if err != nil {
+		return nil, fmt.Errorf("merge: %v: %w", entry.Key, err)
+	}
 	newTS := entry.TimestampNano
-	actualOldVal := oldval[HeaderSize:]
 	if newTS == 0 {
 		// Special handling for main to shadow copy that uses a default timestamp
 		if bytes.Equal(actualOldVal, entryVal) {
@@ -70,24 +125,107 @@ func (it *TimestampedIterator) Merge(oldval []byte) (val []byte, err error) {
 		}
 		newTS = it.DefaultTimestampNano
 	}
-	if newTS < oldTS {
-		// Current LMDB value has a higher timestamp, so keep that one
-		return oldval, nil
+	newVal, ts, err := strategy.Resolve(entry.Key, oldTS, actualOldVal, newTS, entryVal)
+	if err != nil {
+		return nil, fmt.Errorf("merge: %v: %w", entry.Key, err)
+	}
+	if ts == oldTS && bytes.Equal(newVal, actualOldVal) {
+		decision := DecisionKept
+		if newTS == oldTS {
+			decision = DecisionTieKept
+			metrics.ObserveTieBreakRejection(it.DBIName)
+		} else {
+			metrics.ObserveEntryKept(it.DBIName)
+		}
+		it.notifyMerged(entry.Key, oldval, oldval, decision)
+		return oldval, nil // nothing changed, avoid rewriting the header
+	}
+	val, err = it.addTS(newVal, ts, strategy.Version())
+	if err == nil {
+		metrics.ObserveEntryUpdated(it.DBIName)
+		it.notifyMerged(entry.Key, oldval, val, DecisionUpdated)
+	}
+	return val, err
+}
+
+// Clean tombstones key, which existed in the destination DBI but is no
+// longer present in the snapshot being merged in (i.e. it was deleted at
+// the source). key is passed in explicitly rather than read off
+// it.Entries/it.current, since a cleaned key is by definition one that is
+// NOT part of this merge pass's entries.
+func (it *TimestampedIterator) Clean(key, oldval []byte) (val []byte, err error) {
+	// Keep tombstoning the key under the strategy that owns it, falling
+	// back to the DBI's configured strategy if oldval cannot be parsed
+	// (e.g. it is about to be rewritten from the legacy format anyway).
+	// Whether oldval is already a tombstone must also go through
+	// parseHeader rather than a raw length comparison: once LegacyFormat
+	// is set, a live legacy entry with a short value can be exactly
+	// HeaderSize bytes long, the same total length as a bare current-format
+	// tombstone, so total length alone cannot tell them apart.
+	version := it.strategy().Version()
+	if oldVersion, _, plain, err := it.parseHeader(oldval); err == nil {
+		if len(plain) == 0 {
+			return oldval, nil // already deleted, only the header remains
+		}
+		version = oldVersion
+	}
+	val, err = it.addTS(nil, 0, version)
+	if err == nil {
+		metrics.ObserveEntrySeen(it.DBIName)
+		metrics.ObserveTombstoneWritten(it.DBIName)
+		if it.Observer != nil {
+			it.Observer.OnEntryTombstoned(it.DBIName, key, oldval)
+		}
+	}
+	return val, err
+}
+
+func (it *TimestampedIterator) notifyMerged(key, oldVal, newVal []byte, decision MergeDecision) {
+	if it.Observer != nil {
+		it.Observer.OnEntryMerged(it.DBIName, key, oldVal, newVal, decision)
+	}
+}
+
+// parseHeader splits a stored value into the merge strategy version tag,
+// timestamp and plain value encoded in its header.
+//
+// The format is NOT inferred from length: once a value is longer than the
+// bare legacy timestamp, length alone cannot distinguish a live legacy
+// entry from a current-format one (see LegacyFormat). LegacyFormat is the
+// single source of truth for which layout this DBI's values are in. The one
+// exception is a value of exactly legacyHeaderSize bytes, which is too short
+// for the current format and is always read as a legacy LWW tombstone.
+func (it *TimestampedIterator) parseHeader(val []byte) (version byte, ts uint64, plain []byte, err error) {
+	if it.LegacyFormat {
+		if len(val) < legacyHeaderSize {
+			return 0, 0, nil, fmt.Errorf("value too short for legacy header: %d bytes", len(val))
+		}
+		return versionLWW, binary.BigEndian.Uint64(val[:legacyHeaderSize]), val[legacyHeaderSize:], nil
+	}
+	if len(val) == legacyHeaderSize {
+		// Too short for the current format: a bare legacy tombstone.
+		return versionLWW, binary.BigEndian.Uint64(val), nil, nil
 	}
-	if newTS == oldTS && bytes.Compare(actualOldVal, entryVal) <= 0 {
-		// Same timestamp, lexicographic lower value wins for deterministic values,
-		// so return the old value if the plain value was lower or equal.
-		return oldval, nil
+	if len(val) < HeaderSize {
+		return 0, 0, nil, fmt.Errorf("value too short for header: %d bytes", len(val))
 	}
-	// Update LMDB value
-	return it.addTS(entryVal, newTS)
+	return val[0], binary.BigEndian.Uint64(val[1:HeaderSize]), val[HeaderSize:], nil
 }
 
-func (it *TimestampedIterator) Clean(oldval []byte) (val []byte, err error) {
-	if len(oldval) == HeaderSize {
-		return oldval, nil // already deleted, only timestamp
+// headerTimestamp is a best-effort, advisory timestamp extraction for
+// logging only (e.g. in observers), where getting it slightly wrong for an
+// untouched legacy entry is harmless. Anything on the data path that needs
+// a correct answer must go through TimestampedIterator.parseHeader instead,
+// which knows whether this DBI is still in LegacyFormat.
+func headerTimestamp(val []byte) uint64 {
+	switch {
+	case len(val) == legacyHeaderSize:
+		return binary.BigEndian.Uint64(val)
+	case len(val) >= HeaderSize:
+		return binary.BigEndian.Uint64(val[1:HeaderSize])
+	default:
+		return 0
 	}
-	return it.addTS(nil, 0)
 }
 
 func (it *TimestampedIterator) logDebugValue(val []byte) {
@@ -98,10 +236,10 @@ func (it *TimestampedIterator) logDebugValue(val []byte) {
 	}).Debug("LMDB value dump")
 }
 
-// addTS prepends a timestamp header to a plain value. It uses the ts parameter
-// passed in if non-zero, or the default one set on the iterator.
+// addTS prepends a version+timestamp header to a plain value. It uses the ts
+// parameter passed in if non-zero, or the default one set on the iterator.
 // A timestamp is mandatory. If both are 0, an ErrNoTimestamp error is returned.
-func (it *TimestampedIterator) addTS(entryVal []byte, ts uint64) (val []byte, err error) {
+func (it *TimestampedIterator) addTS(entryVal []byte, ts uint64, version byte) (val []byte, err error) {
 	if cap(it.buf) < HeaderSize {
 		it.buf = make([]byte, HeaderSize, 1024)
 	} else {
@@ -110,10 +248,12 @@ func (it *TimestampedIterator) addTS(entryVal []byte, ts uint64) (val []byte, er
 	if ts == 0 {
 		ts = it.DefaultTimestampNano
 		if ts == 0 {
+			metrics.ObserveNoTimestampError(it.DBIName)
 			return nil, ErrNoTimestamp
 		}
 	}
-	binary.BigEndian.PutUint64(it.buf, ts)
+	it.buf[0] = version
+	binary.BigEndian.PutUint64(it.buf[1:HeaderSize], ts)
 	it.buf = append(it.buf, entryVal...)
 	val = it.buf
 	return val, nil
@@ -123,6 +263,7 @@ func (it *TimestampedIterator) addTS(entryVal []byte, ts uint64) (val []byte, er
 // insertion into the main database without the timestamp header.
 type PlainIterator struct {
 	Entries []snapshot.KV // LMDB contents with timestamp to merge
+	DBIName string        // DBI name, used for metrics
 
 	current int
 	started bool
@@ -143,9 +284,13 @@ func (it *PlainIterator) Next() (key []byte, err error) {
 
 func (it *PlainIterator) Merge(oldval []byte) (val []byte, err error) {
 	mainVal := it.Entries[it.current].Value
+	metrics.ObserveEntrySeen(it.DBIName)
+	metrics.ObserveValueSize(it.DBIName, len(mainVal))
+	metrics.ObserveEntryUpdated(it.DBIName)
 	return mainVal, nil
 }
 
 func (it *PlainIterator) Clean(oldval []byte) (val []byte, err error) {
+	metrics.ObserveEntrySeen(it.DBIName)
 	return nil, nil // Delete the key
 }
\ No newline at end of file
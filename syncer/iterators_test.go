@@ -0,0 +1,169 @@
+package syncer
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"powerdns.com/platform/lightningstream/snapshot"
+)
+
+func newTestIterator(entries ...snapshot.KV) *TimestampedIterator {
+	return &TimestampedIterator{Entries: entries}
+}
+
+func mustParseHeader(t *testing.T, it *TimestampedIterator, val []byte) (byte, uint64, []byte) {
+	t.Helper()
+	version, ts, plain, err := it.parseHeader(val)
+	if err != nil {
+		t.Fatalf("parseHeader: %v", err)
+	}
+	return version, ts, plain
+}
+
+func TestMerge_InsertsNewKeyWithConfiguredStrategy(t *testing.T) {
+	it := newTestIterator(snapshot.KV{Key: []byte("k"), Value: []byte("v"), TimestampNano: 100})
+	it.Strategy = MaxValueStrategy{}
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	val, err := it.Merge(nil)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	version, ts, plain, err := it.parseHeader(val)
+	if err != nil {
+		t.Fatalf("parseHeader: %v", err)
+	}
+	if version != versionMaxValue || ts != 100 || string(plain) != "v" {
+		t.Fatalf("got version=%d ts=%d plain=%q", version, ts, plain)
+	}
+}
+
+func TestMerge_ResolvesWithTheStoredEntrysOwnStrategy(t *testing.T) {
+	// The old value was written under MaxValueStrategy, but the DBI's
+	// currently configured strategy has since changed to LWW. Merge must
+	// still resolve this entry with MaxValueStrategy, not the DBI's current
+	// configuration, or it misreads the 8-byte counter as a plain value.
+	it := newTestIterator(snapshot.KV{Key: []byte("k"), Value: counterBytes(3), TimestampNano: 50})
+	it.Strategy = LWWStrategy{} // configured strategy today, not the one that wrote oldval
+
+	oldval, err := it.addTS(counterBytes(9), 100, versionMaxValue)
+	if err != nil {
+		t.Fatalf("addTS: %v", err)
+	}
+	oldval = append([]byte(nil), oldval...)
+
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	val, err := it.Merge(oldval)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	version, ts, plain, err := it.parseHeader(val)
+	if err != nil {
+		t.Fatalf("parseHeader: %v", err)
+	}
+	if version != versionMaxValue {
+		t.Fatalf("got version %d, want versionMaxValue", version)
+	}
+	if binary.BigEndian.Uint64(plain) != 9 {
+		t.Fatalf("got counter %d, want 9 (the larger of 3 and 9)", binary.BigEndian.Uint64(plain))
+	}
+	if ts != 100 {
+		t.Fatalf("got ts %d, want 100", ts)
+	}
+}
+
+func TestMerge_LegacyLiveEntryIsNotCorrupted(t *testing.T) {
+	// A live legacy entry (bare 8 byte timestamp + value, no version byte)
+	// longer than legacyHeaderSize must not be misparsed as a current-format
+	// header just because its total length happens to be >= HeaderSize.
+	it := newTestIterator(snapshot.KV{Key: []byte("k"), Value: []byte("new-value"), TimestampNano: 200})
+	it.LegacyFormat = true
+
+	legacyOld := make([]byte, 8, 8+len("old-value"))
+	binary.BigEndian.PutUint64(legacyOld, 100)
+	legacyOld = append(legacyOld, []byte("old-value")...) // 8 + 9 = 17 bytes, > HeaderSize
+
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	val, err := it.Merge(legacyOld)
+	if err != nil {
+		t.Fatalf("Merge: %v", err)
+	}
+	// The incoming entry is newer, so it should win outright and be
+	// re-encoded in the current format.
+	it.LegacyFormat = false
+	version, ts, plain, err := it.parseHeader(val)
+	if err != nil {
+		t.Fatalf("parseHeader: %v", err)
+	}
+	if version != versionLWW || ts != 200 || string(plain) != "new-value" {
+		t.Fatalf("got version=%d ts=%d plain=%q, want version=%d ts=200 plain=%q",
+			version, ts, plain, versionLWW, "new-value")
+	}
+}
+
+func TestClean_TombstonesAndIsIdempotent(t *testing.T) {
+	it := &TimestampedIterator{DefaultTimestampNano: 300}
+	oldval, err := it.addTS([]byte("v"), 100, versionSetUnion)
+	if err != nil {
+		t.Fatalf("addTS: %v", err)
+	}
+	oldval = append([]byte(nil), oldval...)
+
+	tomb, err := it.Clean([]byte("k"), oldval)
+	if err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	_, _, plain := mustParseHeader(t, it, tomb)
+	if len(plain) != 0 {
+		t.Fatalf("expected an empty plain value after Clean, got %q", plain)
+	}
+
+	// Cleaning an already-tombstoned entry is a no-op.
+	tomb2, err := it.Clean([]byte("k"), tomb)
+	if err != nil {
+		t.Fatalf("Clean on already-tombstoned entry: %v", err)
+	}
+	if string(tomb2) != string(tomb) {
+		t.Fatalf("re-cleaning a tombstone changed its value")
+	}
+}
+
+func TestClean_DoesNotMistakeALiveLegacyEntryForATombstone(t *testing.T) {
+	// With LegacyFormat set, a live legacy entry with a 1 byte value is
+	// legacyHeaderSize(8) + 1 = 9 bytes, the same total length as a bare
+	// current-format tombstone (HeaderSize = 9, no value). Clean must tell
+	// these apart by content, not by length alone.
+	it := &TimestampedIterator{LegacyFormat: true, DefaultTimestampNano: 300}
+	legacyLive := make([]byte, 8, 9)
+	binary.BigEndian.PutUint64(legacyLive, 100)
+	legacyLive = append(legacyLive, 'x') // 9 bytes total, has a real value
+
+	val, err := it.Clean([]byte("k"), legacyLive)
+	if err != nil {
+		t.Fatalf("Clean: %v", err)
+	}
+	// Clean always (re-)writes in the current header format, regardless of
+	// LegacyFormat; flip it off here to read back what was actually
+	// written, same as a caller would once migration of this DBI finishes.
+	it.LegacyFormat = false
+	_, _, plain := mustParseHeader(t, it, val)
+	if len(plain) != 0 {
+		t.Fatalf("expected Clean to tombstone the live legacy entry, got plain=%q", plain)
+	}
+}
+
+func TestNext_EOF(t *testing.T) {
+	it := newTestIterator(snapshot.KV{Key: []byte("k"), Value: []byte("v"), TimestampNano: 1})
+	if _, err := it.Next(); err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if _, err := it.Next(); err != io.EOF {
+		t.Fatalf("Next at end = %v, want io.EOF", err)
+	}
+}
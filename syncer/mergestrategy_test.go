@@ -0,0 +1,138 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"powerdns.com/platform/lightningstream/config"
+)
+
+func TestLWWStrategy_Resolve(t *testing.T) {
+	s := LWWStrategy{}
+	cases := []struct {
+		name    string
+		oldTS   uint64
+		oldVal  string
+		newTS   uint64
+		newVal  string
+		wantVal string
+		wantTS  uint64
+	}{
+		{"newer wins", 100, "old", 200, "new", "new", 200},
+		{"older loses", 200, "old", 100, "new", "old", 200},
+		{"tie keeps lexicographically lower", 100, "aaa", 100, "bbb", "aaa", 100},
+		{"tie keeps lexicographically lower, reversed input", 100, "bbb", 100, "aaa", "aaa", 100},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			val, ts, err := s.Resolve([]byte("k"), c.oldTS, []byte(c.oldVal), c.newTS, []byte(c.newVal))
+			if err != nil {
+				t.Fatalf("Resolve: %v", err)
+			}
+			if string(val) != c.wantVal || ts != c.wantTS {
+				t.Fatalf("got val=%q ts=%d, want val=%q ts=%d", val, ts, c.wantVal, c.wantTS)
+			}
+		})
+	}
+	if s.Version() != versionLWW {
+		t.Fatalf("Version() = %d, want %d", s.Version(), versionLWW)
+	}
+}
+
+func counterBytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+func TestMaxValueStrategy_Resolve(t *testing.T) {
+	s := MaxValueStrategy{}
+	val, ts, err := s.Resolve([]byte("k"), 100, counterBytes(5), 50, counterBytes(9))
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if binary.BigEndian.Uint64(val) != 9 {
+		t.Fatalf("got counter %d, want 9", binary.BigEndian.Uint64(val))
+	}
+	// The timestamp always advances to the highest one seen, even though
+	// the lower counter arrived with the higher timestamp here.
+	if ts != 100 {
+		t.Fatalf("got ts %d, want 100", ts)
+	}
+	if _, _, err := s.Resolve([]byte("k"), 0, []byte("short"), 0, counterBytes(1)); err == nil {
+		t.Fatal("expected an error decoding a non-8-byte counter")
+	}
+	if s.Version() != versionMaxValue {
+		t.Fatalf("Version() = %d, want %d", s.Version(), versionMaxValue)
+	}
+}
+
+func TestSetUnionStrategy_Resolve(t *testing.T) {
+	s := SetUnionStrategy{}
+	old := encodeSortedSet([][]byte{[]byte("a"), []byte("c")})
+	newSet := encodeSortedSet([][]byte{[]byte("b"), []byte("c")})
+	val, ts, err := s.Resolve([]byte("k"), 100, old, 200, newSet)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	got, err := decodeSortedSet(val)
+	if err != nil {
+		t.Fatalf("decodeSortedSet: %v", err)
+	}
+	want := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	if len(got) != len(want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+	if ts != 200 {
+		t.Fatalf("got ts %d, want 200", ts)
+	}
+	if s.Version() != versionSetUnion {
+		t.Fatalf("Version() = %d, want %d", s.Version(), versionSetUnion)
+	}
+}
+
+func TestStrategyForVersion(t *testing.T) {
+	cases := []struct {
+		version byte
+		want    MergeStrategy
+	}{
+		{versionLWW, LWWStrategy{}},
+		{versionMaxValue, MaxValueStrategy{}},
+		{versionSetUnion, SetUnionStrategy{}},
+	}
+	for _, c := range cases {
+		got, err := strategyForVersion(c.version)
+		if err != nil {
+			t.Fatalf("strategyForVersion(%d): %v", c.version, err)
+		}
+		if got != c.want {
+			t.Fatalf("strategyForVersion(%d) = %#v, want %#v", c.version, got, c.want)
+		}
+	}
+	if _, err := strategyForVersion(255); err == nil {
+		t.Fatal("expected an error for an unknown version tag")
+	}
+}
+
+func TestNewMergeStrategy_UnknownName(t *testing.T) {
+	if _, err := NewMergeStrategy("bogus"); err == nil {
+		t.Fatal("expected an error for an unknown strategy name")
+	}
+}
+
+func TestStrategyForDBI(t *testing.T) {
+	if got, err := StrategyForDBI(config.DBI{MergeStrategy: "max-value"}); err != nil {
+		t.Fatalf("StrategyForDBI: %v", err)
+	} else if _, ok := got.(MaxValueStrategy); !ok {
+		t.Fatalf("got %#v, want MaxValueStrategy", got)
+	}
+	if _, err := StrategyForDBI(config.DBI{MergeStrategy: "bogus"}); err == nil {
+		t.Fatal("expected an error for an unknown strategy name")
+	}
+}
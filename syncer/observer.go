@@ -0,0 +1,75 @@
+package syncer
+
+import "time"
+
+// MergeDecision identifies how an entry merge was resolved, for the benefit
+// of MergeObserver implementations.
+type MergeDecision string
+
+const (
+	DecisionInserted   MergeDecision = "inserted"   // key did not exist in the destination before
+	DecisionUpdated    MergeDecision = "updated"    // the new value won and was written
+	DecisionKept       MergeDecision = "kept"       // the old value won because its timestamp was strictly newer
+	DecisionTieKept    MergeDecision = "tie_kept"   // the old value won a same-timestamp tie-break
+	DecisionTombstoned MergeDecision = "tombstoned" // the key was deleted
+)
+
+// SnapshotMeta describes the remote snapshot a merge pass was applied from.
+type SnapshotMeta struct {
+	Peer       string
+	Generation uint64
+	Timestamp  time.Time
+}
+
+// MergeStats summarizes the outcome of merging a snapshot, so callers
+// embedding lightningstream as a library can read the same counts an
+// observer or the metrics subpackage would see.
+type MergeStats struct {
+	EntriesSeen        int
+	EntriesUpdated     int
+	EntriesKept        int
+	TieBreakRejections int
+	TombstonesWritten  int
+	Errors             int // ErrNoTimestamp occurrences
+}
+
+// MergeObserver lets operators react to merge events without patching the
+// core. Implementations must not block: TimestampedIterator.Merge/Clean and
+// the snapshot-apply loop call these synchronously from the merge txn, so
+// any I/O must happen on a separate goroutine fed through a channel.
+type MergeObserver interface {
+	// OnEntryMerged is called for every key whose merge resolves to a
+	// decision, whether or not its value actually changed. It is not called
+	// when the merge fails, nor for a main-to-shadow copy (an entry without
+	// a timestamp of its own) whose value equals the stored one. oldVal and newVal are the
+	// full header-prefixed values as stored in the shadow DBI; oldVal is
+	// nil when the key did not exist before.
+	OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision)
+	// OnEntryTombstoned is called when a key is deleted and replaced by a
+	// tombstone.
+	OnEntryTombstoned(dbi string, key, oldVal []byte)
+	// OnSnapshotApplied is called once a whole remote snapshot has been
+	// merged in.
+	OnSnapshotApplied(meta SnapshotMeta, stats MergeStats)
+}
+
+// MultiObserver fans merge events out to multiple observers, in order.
+type MultiObserver []MergeObserver
+
+func (m MultiObserver) OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision) {
+	for _, o := range m {
+		o.OnEntryMerged(dbi, key, oldVal, newVal, decision)
+	}
+}
+
+func (m MultiObserver) OnEntryTombstoned(dbi string, key, oldVal []byte) {
+	for _, o := range m {
+		o.OnEntryTombstoned(dbi, key, oldVal)
+	}
+}
+
+func (m MultiObserver) OnSnapshotApplied(meta SnapshotMeta, stats MergeStats) {
+	for _, o := range m {
+		o.OnSnapshotApplied(meta, stats)
+	}
+}
@@ -0,0 +1,156 @@
+package syncer
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeLogObserver appends one logfmt line per changed key to Path,
+// rotating the file once it exceeds MaxSizeBytes. It implements
+// MergeObserver.
+//
+// OnEntryMerged/OnEntryTombstoned/OnSnapshotApplied are called synchronously
+// from inside the merge txn, so they must never block on file I/O: lines
+// are pushed onto a buffered channel and a single worker goroutine does the
+// actual writing (including rotation) off that critical path. Once the
+// buffer is full, further lines are dropped and counted in Dropped rather
+// than stalling replication.
+type ChangeLogObserver struct {
+	Path         string
+	MaxSizeBytes int64
+
+	file     *os.File // only ever touched by the worker goroutine
+	size     int64    // only ever touched by the worker goroutine
+	lines    chan string
+	done     chan struct{}
+	exited   chan struct{} // closed by the worker goroutine once file is closed
+	closeErr error
+
+	Dropped uint64 // atomic: lines dropped because the buffer was full
+}
+
+// NewChangeLogObserver opens (or creates) the change log at path and starts
+// its worker goroutine. bufferSize is the number of pending lines that may
+// be buffered before lines are dropped. Call Close to stop the worker.
+func NewChangeLogObserver(path string, maxSizeBytes int64, bufferSize int) (*ChangeLogObserver, error) {
+	c := &ChangeLogObserver{
+		Path:         path,
+		MaxSizeBytes: maxSizeBytes,
+		lines:        make(chan string, bufferSize),
+		done:         make(chan struct{}),
+		exited:       make(chan struct{}),
+	}
+	if err := c.openCurrent(); err != nil {
+		return nil, err
+	}
+	go c.run()
+	return c, nil
+}
+
+func (c *ChangeLogObserver) openCurrent() error {
+	f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("changelog: open %s: %w", c.Path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("changelog: stat %s: %w", c.Path, err)
+	}
+	c.file = f
+	c.size = info.Size()
+	return nil
+}
+
+func (c *ChangeLogObserver) run() {
+	defer close(c.exited)
+	for {
+		select {
+		case line := <-c.lines:
+			c.writeLine(line)
+		case <-c.done:
+			// select does not prefer c.lines over c.done when both are
+			// ready, so drain whatever is still buffered before closing
+			// the file or a just-pushed line can be lost.
+			for drained := false; !drained; {
+				select {
+				case line := <-c.lines:
+					c.writeLine(line)
+				default:
+					drained = true
+				}
+			}
+			c.closeErr = c.file.Close()
+			return
+		}
+	}
+}
+
+func (c *ChangeLogObserver) push(line string) {
+	select {
+	case c.lines <- line:
+	default:
+		atomic.AddUint64(&c.Dropped, 1)
+	}
+}
+
+func (c *ChangeLogObserver) OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision) {
+	if decision == DecisionKept || decision == DecisionTieKept {
+		return // the stored value did not change
+	}
+	c.push(fmt.Sprintf("time=%s dbi=%s key=%s old_ts=%d new_ts=%d decision=%s",
+		time.Now().UTC().Format(time.RFC3339Nano), dbi, hex.EncodeToString(key), headerTimestamp(oldVal), headerTimestamp(newVal), decision))
+}
+
+func (c *ChangeLogObserver) OnEntryTombstoned(dbi string, key, oldVal []byte) {
+	c.push(fmt.Sprintf("time=%s dbi=%s key=%s old_ts=%d decision=%s",
+		time.Now().UTC().Format(time.RFC3339Nano), dbi, hex.EncodeToString(key), headerTimestamp(oldVal), DecisionTombstoned))
+}
+
+func (c *ChangeLogObserver) OnSnapshotApplied(meta SnapshotMeta, stats MergeStats) {
+	c.push(fmt.Sprintf("time=%s peer=%s generation=%d entries_seen=%d entries_updated=%d tombstones_written=%d",
+		time.Now().UTC().Format(time.RFC3339Nano), meta.Peer, meta.Generation,
+		stats.EntriesSeen, stats.EntriesUpdated, stats.TombstonesWritten))
+}
+
+// writeLine is only ever called from the worker goroutine in run.
+func (c *ChangeLogObserver) writeLine(line string) {
+	if c.MaxSizeBytes > 0 && c.size >= c.MaxSizeBytes {
+		if err := c.rotate(); err != nil {
+			logrus.WithError(err).Error("changelog: rotate failed")
+		}
+	}
+	n, err := fmt.Fprintln(c.file, line)
+	if err != nil {
+		logrus.WithError(err).Error("changelog: write failed")
+		return
+	}
+	c.size += int64(n)
+}
+
+// rotate closes the current file, renames it aside with a timestamp suffix,
+// and opens a fresh one at Path.
+func (c *ChangeLogObserver) rotate() error {
+	if err := c.file.Close(); err != nil {
+		return fmt.Errorf("changelog: close before rotate: %w", err)
+	}
+	rotated := c.Path + "." + time.Now().UTC().Format("20060102T150405.000000000Z")
+	if err := os.Rename(c.Path, rotated); err != nil {
+		return fmt.Errorf("changelog: rename to %s: %w", rotated, err)
+	}
+	return c.openCurrent()
+}
+
+// Close stops the worker goroutine and closes the underlying file, waiting
+// for it to exit so the two never touch the file concurrently. Any lines
+// still buffered on the channel are written before the file is closed.
+func (c *ChangeLogObserver) Close() error {
+	close(c.done)
+	<-c.exited
+	return c.closeErr
+}
@@ -0,0 +1,109 @@
+package syncer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChangeLogObserver_DropsOnOverflowInsteadOfBlocking(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "changelog")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	f.Close()
+
+	c, err := NewChangeLogObserver(f.Name(), 0, 1)
+	if err != nil {
+		t.Fatalf("NewChangeLogObserver: %v", err)
+	}
+	defer c.Close()
+
+	// Hold the worker goroutine's single buffer slot full, by blocking its
+	// file write; simplest without a fake filesystem is to just push more
+	// events than the buffer can hold in a tight loop, fast enough that at
+	// least one is dropped rather than waiting on a slow disk.
+	const n = 1000
+	for i := 0; i < n; i++ {
+		c.OnEntryMerged("dbi", []byte("k"), nil, nil, DecisionInserted)
+	}
+	if atomic.LoadUint64(&c.Dropped) == 0 {
+		t.Fatal("expected at least one dropped line when pushing far more than bufferSize events")
+	}
+}
+
+func TestChangeLogObserver_CloseFlushesBufferedLine(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "changelog")
+	if err != nil {
+		t.Fatalf("CreateTemp: %v", err)
+	}
+	path := f.Name()
+	f.Close()
+
+	c, err := NewChangeLogObserver(path, 0, 10)
+	if err != nil {
+		t.Fatalf("NewChangeLogObserver: %v", err)
+	}
+	c.OnEntryMerged("dbi", []byte("k"), nil, nil, DecisionInserted)
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if !strings.Contains(string(data), "dbi=dbi") {
+		t.Fatalf("expected Close to flush the buffered line before closing the file, got %q", data)
+	}
+}
+
+func TestWebhookObserver_DropsOnOverflowInsteadOfBlocking(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second) // keep the worker busy well inside the client timeout
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	conf := DefaultWebhookObserverConfig(srv.URL)
+	conf.BufferSize = 1
+	conf.BatchSize = 1
+	conf.FlushInterval = time.Millisecond
+	conf.MaxRetries = 0
+	w := NewWebhookObserver(conf)
+	defer w.Close()
+
+	const n = 1000
+	for i := 0; i < n; i++ {
+		w.OnEntryMerged("dbi", []byte("k"), nil, nil, DecisionInserted)
+	}
+	if atomic.LoadUint64(&w.Dropped) == 0 {
+		t.Fatal("expected at least one dropped event once the worker stalls on a slow/blocked webhook")
+	}
+}
+
+func TestWebhookObserver_CloseWaitsForFinalFlush(t *testing.T) {
+	var gotBatch int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&gotBatch, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	conf := DefaultWebhookObserverConfig(srv.URL)
+	conf.BufferSize = 10
+	conf.BatchSize = 10
+	conf.FlushInterval = time.Hour // force Close's flush to be the only flush
+	w := NewWebhookObserver(conf)
+	w.OnEntryMerged("dbi", []byte("k"), nil, nil, DecisionInserted)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if atomic.LoadInt32(&gotBatch) != 1 {
+		t.Fatalf("got %d POSTs, want Close to have flushed exactly one batch before returning", gotBatch)
+	}
+}
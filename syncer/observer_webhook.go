@@ -0,0 +1,196 @@
+package syncer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ChangeEvent is one entry in a webhook batch.
+type ChangeEvent struct {
+	DBI      string        `json:"dbi"`
+	Key      string        `json:"key"` // hex-encoded
+	OldTS    uint64        `json:"old_ts,omitempty"`
+	NewTS    uint64        `json:"new_ts,omitempty"`
+	Decision MergeDecision `json:"decision"`
+}
+
+// WebhookObserverConfig configures a WebhookObserver.
+type WebhookObserverConfig struct {
+	URL            string
+	BatchSize      int           // events per POST
+	FlushInterval  time.Duration // max time an event waits in a partial batch before being flushed
+	BufferSize     int           // ring buffer capacity; events beyond this are dropped
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultWebhookObserverConfig returns sane defaults for url.
+func DefaultWebhookObserverConfig(url string) WebhookObserverConfig {
+	return WebhookObserverConfig{
+		URL:            url,
+		BatchSize:      100,
+		FlushInterval:  5 * time.Second,
+		BufferSize:     10000,
+		MaxRetries:     5,
+		InitialBackoff: 500 * time.Millisecond,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// WebhookObserver batches change events and POSTs them as JSON to a
+// configurable URL with retry/backoff. It never blocks the merge txn:
+// events are pushed onto a buffered channel (acting as a ring buffer) that
+// a single worker goroutine drains; once the buffer is full, further
+// events are dropped and counted in Dropped rather than stalling
+// replication.
+type WebhookObserver struct {
+	conf   WebhookObserverConfig
+	client *http.Client
+	events chan ChangeEvent
+	done   chan struct{}
+	exited chan struct{} // closed by the worker goroutine once its final flush is done
+
+	Dropped uint64 // atomic: events dropped because the buffer was full
+}
+
+// NewWebhookObserver starts the worker goroutine and returns a ready
+// WebhookObserver. Call Close to stop it.
+func NewWebhookObserver(conf WebhookObserverConfig) *WebhookObserver {
+	w := &WebhookObserver{
+		conf:   conf,
+		client: &http.Client{Timeout: 30 * time.Second},
+		events: make(chan ChangeEvent, conf.BufferSize),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *WebhookObserver) push(ev ChangeEvent) {
+	select {
+	case w.events <- ev:
+	default:
+		atomic.AddUint64(&w.Dropped, 1)
+	}
+}
+
+func (w *WebhookObserver) OnEntryMerged(dbi string, key, oldVal, newVal []byte, decision MergeDecision) {
+	w.push(ChangeEvent{
+		DBI: dbi, Key: hex.EncodeToString(key),
+		OldTS: headerTimestamp(oldVal), NewTS: headerTimestamp(newVal),
+		Decision: decision,
+	})
+}
+
+func (w *WebhookObserver) OnEntryTombstoned(dbi string, key, oldVal []byte) {
+	w.push(ChangeEvent{DBI: dbi, Key: hex.EncodeToString(key), OldTS: headerTimestamp(oldVal), Decision: DecisionTombstoned})
+}
+
+func (w *WebhookObserver) OnSnapshotApplied(meta SnapshotMeta, stats MergeStats) {
+	// Snapshot-level summaries are not batched as per-key change events.
+}
+
+func (w *WebhookObserver) run() {
+	defer close(w.exited)
+	batch := make([]ChangeEvent, 0, w.conf.BatchSize)
+	ticker := time.NewTicker(w.conf.FlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.post(batch); err != nil {
+			logrus.WithError(err).WithField("count", len(batch)).Error("webhook: dropping batch after retries exhausted")
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case ev := <-w.events:
+			batch = append(batch, ev)
+			if len(batch) >= w.conf.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-w.done:
+			// select does not prefer w.events over w.done when both are
+			// ready, so drain whatever is still buffered before the final
+			// flush or a just-pushed event can be lost.
+			for drained := false; !drained; {
+				select {
+				case ev := <-w.events:
+					batch = append(batch, ev)
+					if len(batch) >= w.conf.BatchSize {
+						flush()
+					}
+				default:
+					drained = true
+				}
+			}
+			flush()
+			return
+		}
+	}
+}
+
+func (w *WebhookObserver) post(batch []ChangeEvent) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("webhook: marshal batch: %w", err)
+	}
+	backoff := w.conf.InitialBackoff
+	var lastErr error
+	for attempt := 0; attempt <= w.conf.MaxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > w.conf.MaxBackoff {
+				backoff = w.conf.MaxBackoff
+			}
+		}
+		lastErr = w.postOnce(body)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (w *WebhookObserver) postOnce(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook: do request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close flushes any buffered events and stops the worker goroutine, waiting
+// for its final flush (which may retry with backoff) to finish before
+// returning, so a caller that exits right after Close does not lose the
+// last batch.
+func (w *WebhookObserver) Close() error {
+	close(w.done)
+	<-w.exited
+	return nil
+}